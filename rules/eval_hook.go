@@ -0,0 +1,137 @@
+// Copyright 2013 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rules
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// EvalHook wraps a single step of rule evaluation. It receives the rule
+// being evaluated, the evaluation timestamp, and the vector and error
+// produced so far, and returns the vector and error to pass to the next
+// hook. RecordingRule.Eval runs the chain only after the query succeeded and
+// stops it at the first hook error, so the error a hook receives from Eval
+// is always nil. A hook may mutate
+// labels or drop samples by returning a different vector, and may
+// short-circuit the remaining chain by returning a non-nil error; Eval then
+// sets the rule's health to HealthBad with that error as LastError.
+type EvalHook func(ctx context.Context, rule Rule, ts time.Time, vector promql.Vector, err error) (promql.Vector, error)
+
+// runEvalHooks threads vector/err through each hook in order, stopping at
+// the first error.
+func runEvalHooks(ctx context.Context, rule Rule, ts time.Time, hooks []EvalHook, vector promql.Vector) (promql.Vector, error) {
+	var err error
+	for _, hook := range hooks {
+		vector, err = hook(ctx, rule, ts, vector, err)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return vector, nil
+}
+
+// LimitHook returns a hook that fails evaluation once the vector exceeds
+// limit series. A non-positive limit disables the check. This is the hook
+// equivalent of the legacy limit parameter to RecordingRule.Eval.
+func LimitHook(limit int) EvalHook {
+	return func(_ context.Context, _ Rule, _ time.Time, vector promql.Vector, err error) (promql.Vector, error) {
+		if err != nil || limit <= 0 {
+			return vector, err
+		}
+		if len(vector) > limit {
+			return vector, fmt.Errorf("exceeded limit of %d with %d series", limit, len(vector))
+		}
+		return vector, nil
+	}
+}
+
+// ScrubLabelsHook returns a hook that removes the given label names from
+// every sample's metric, e.g. to strip labels that should never be
+// persisted to storage.
+func ScrubLabelsHook(names ...string) EvalHook {
+	return func(_ context.Context, _ Rule, _ time.Time, vector promql.Vector, err error) (promql.Vector, error) {
+		if err != nil {
+			return vector, err
+		}
+		for i := range vector {
+			lb := labels.NewBuilder(vector[i].Metric)
+			for _, n := range names {
+				lb.Del(n)
+			}
+			vector[i].Metric = lb.Labels()
+		}
+		return vector, nil
+	}
+}
+
+// SampleCountHistogramHook returns a hook that observes, in histogram labeled
+// by rule name, the number of samples in the vector it receives at its
+// position in the chain. Samples dropped by later hooks are still counted,
+// and the staleness markers and kept series that Eval adds after the chain
+// are not.
+func SampleCountHistogramHook(histogram *prometheus.HistogramVec) EvalHook {
+	return func(_ context.Context, rule Rule, _ time.Time, vector promql.Vector, err error) (promql.Vector, error) {
+		if err == nil {
+			histogram.WithLabelValues(rule.Name()).Observe(float64(len(vector)))
+		}
+		return vector, err
+	}
+}
+
+// AssertHook returns a hook that fails evaluation — setting the rule's
+// health to HealthBad with a descriptive LastError — when assert returns a
+// non-nil error for the resulting vector.
+func AssertHook(assert func(promql.Vector) error) EvalHook {
+	return func(_ context.Context, _ Rule, _ time.Time, vector promql.Vector, err error) (promql.Vector, error) {
+		if err != nil {
+			return vector, err
+		}
+		if aerr := assert(vector); aerr != nil {
+			return vector, aerr
+		}
+		return vector, nil
+	}
+}
+
+// ExactSeriesCount returns an AssertHook invariant requiring the rule to
+// return exactly n series.
+func ExactSeriesCount(n int) func(promql.Vector) error {
+	return func(vector promql.Vector) error {
+		if len(vector) != n {
+			return fmt.Errorf("expected exactly %d series, got %d", n, len(vector))
+		}
+		return nil
+	}
+}
+
+// AllValuesFinite returns an AssertHook invariant requiring every sample
+// value to be finite (no NaN or Inf).
+func AllValuesFinite() func(promql.Vector) error {
+	return func(vector promql.Vector) error {
+		for _, s := range vector {
+			if math.IsNaN(s.V) || math.IsInf(s.V, 0) {
+				return fmt.Errorf("non-finite value %v for %s", s.V, s.Metric)
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2013 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rules
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// QueryFunc is a function that executes a PromQL query at the given time and
+// returns the result as a vector.
+type QueryFunc func(ctx context.Context, q string, t time.Time) (promql.Vector, error)
+
+// EngineQueryFunc returns a new query function that executes instant queries
+// against the given engine.
+func EngineQueryFunc(engine *promql.Engine, q storage.Queryable) QueryFunc {
+	return func(ctx context.Context, qs string, t time.Time) (promql.Vector, error) {
+		query, err := engine.NewInstantQuery(q, nil, qs, t)
+		if err != nil {
+			return nil, err
+		}
+		res := query.Exec(ctx)
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		switch v := res.Value.(type) {
+		case promql.Vector:
+			return v, nil
+		case promql.Scalar:
+			return promql.Vector{promql.Sample{
+				Point:  promql.Point(v),
+				Metric: labels.Labels{},
+			}}, nil
+		default:
+			return nil, errors.New("rule result is not a vector or scalar")
+		}
+	}
+}
+
+// GroupOptions bundles the options used when constructing a Group. The
+// group-level defaults here apply to the group's recording rules only; other
+// rules are left unchanged.
+type GroupOptions struct {
+	Name     string
+	Interval time.Duration
+	Rules    []Rule
+
+	// DefaultKeepRecordingFor is applied to recording rules in this group
+	// that don't set their own KeepRecordingFor.
+	DefaultKeepRecordingFor time.Duration
+
+	// EvalHooks are appended to the chain of every recording rule in this
+	// group, running after any hooks the rule already had registered.
+	EvalHooks []EvalHook
+}
+
+// Group is a set of rules that are evaluated on a given interval.
+type Group struct {
+	name     string
+	interval time.Duration
+	rules    []Rule
+}
+
+// NewGroup returns a new Group, applying any group-level defaults to the
+// rules it was given.
+func NewGroup(o GroupOptions) *Group {
+	for _, r := range o.Rules {
+		rr, ok := r.(*RecordingRule)
+		if !ok {
+			continue
+		}
+		if o.DefaultKeepRecordingFor > 0 && rr.KeepRecordingFor() == 0 {
+			rr.SetKeepRecordingFor(o.DefaultKeepRecordingFor)
+		}
+		for _, hook := range o.EvalHooks {
+			rr.AppendEvalHook(hook)
+		}
+	}
+	return &Group{
+		name:     o.Name,
+		interval: o.Interval,
+		rules:    o.Rules,
+	}
+}
+
+// Name returns the group name.
+func (g *Group) Name() string { return g.name }
+
+// Rules returns the group's rules.
+func (g *Group) Rules() []Rule { return g.rules }
@@ -0,0 +1,280 @@
+// Copyright 2013 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rules
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// seenSeries tracks the last value recorded for a series so that it can be
+// kept alive or marked stale once it stops being returned by the rule query.
+type seenSeries struct {
+	sample promql.Sample
+	// droppedAt is the time at which the series was first observed to be
+	// missing from the query result. It is the zero Time while the series
+	// is still present.
+	droppedAt time.Time
+}
+
+// A RecordingRule records its vector expression into new timeseries.
+type RecordingRule struct {
+	name   string
+	vector parser.Expr
+	labels labels.Labels
+
+	// keepRecordingFor is the duration for which the last recorded value of
+	// a series is re-emitted after it drops out of the query result, before
+	// a staleness marker is emitted for it. Zero means a staleness marker is
+	// emitted immediately on drop-out.
+	keepRecordingFor time.Duration
+
+	// clock returns the current time used to track how long a series has
+	// been missing. It is overridden in tests.
+	clock func() time.Time
+
+	mtx                 sync.Mutex
+	evaluationTimestamp time.Time
+	evaluationDuration  time.Duration
+	lastError           error
+	health              RuleHealth
+	seen                map[uint64]seenSeries
+	hooks               []EvalHook
+}
+
+// NewRecordingRule returns a new recording rule.
+func NewRecordingRule(name string, vector parser.Expr, lset labels.Labels) *RecordingRule {
+	return &RecordingRule{
+		name:   name,
+		vector: vector,
+		labels: lset,
+		health: HealthUnknown,
+		clock:  time.Now,
+		seen:   map[uint64]seenSeries{},
+	}
+}
+
+// Name returns the rule name.
+func (rule *RecordingRule) Name() string {
+	return rule.name
+}
+
+// Query returns the rule query expression.
+func (rule *RecordingRule) Query() parser.Expr {
+	return rule.vector
+}
+
+// Labels returns the labels of the rule.
+func (rule *RecordingRule) Labels() labels.Labels {
+	return rule.labels
+}
+
+// KeepRecordingFor returns the duration for which the rule keeps re-emitting
+// the last value of a series that has dropped out of the query result.
+func (rule *RecordingRule) KeepRecordingFor() time.Duration {
+	rule.mtx.Lock()
+	defer rule.mtx.Unlock()
+	return rule.keepRecordingFor
+}
+
+// SetKeepRecordingFor sets the duration for which the rule keeps re-emitting
+// the last value of a series that has dropped out of the query result,
+// before marking it stale.
+func (rule *RecordingRule) SetKeepRecordingFor(d time.Duration) {
+	rule.mtx.Lock()
+	defer rule.mtx.Unlock()
+	rule.keepRecordingFor = d
+}
+
+// AppendEvalHook registers an additional EvalHook to run, after the query
+// result has had rule labels applied and before staleness handling. Hooks
+// run in the order they were appended.
+func (rule *RecordingRule) AppendEvalHook(hook EvalHook) {
+	rule.mtx.Lock()
+	defer rule.mtx.Unlock()
+	rule.hooks = append(rule.hooks, hook)
+}
+
+// Eval evaluates the rule and returns the resulting vector. Once rule labels
+// have been applied, the vector is passed through the rule's chain of
+// EvalHooks (the legacy limit parameter is itself applied as the first hook
+// in the chain); any hook can mutate or short-circuit evaluation. In
+// addition to the samples that survive the hook chain, Eval emits a
+// staleness marker for any series that was present on a previous evaluation
+// but is no longer returned, and (if KeepRecordingFor is set) keeps
+// re-emitting a dropped series' last value for a bounded window before
+// marking it stale.
+func (rule *RecordingRule) Eval(ctx context.Context, ts time.Time, query QueryFunc, _ *url.URL, limit int) (promql.Vector, error) {
+	vector, err := query(ctx, rule.vector.String(), ts)
+	if err != nil {
+		rule.SetHealth(HealthBad)
+		rule.SetLastError(err)
+		return nil, err
+	}
+	rule.SetHealth(HealthGood)
+	rule.SetLastError(nil)
+
+	// Override the metric name and labels.
+	for i := range vector {
+		sample := &vector[i]
+
+		lb := labels.NewBuilder(sample.Metric).Set(labels.MetricName, rule.name)
+		for _, l := range rule.labels {
+			lb.Set(l.Name, l.Value)
+		}
+		sample.Metric = lb.Labels()
+	}
+
+	// Check that the rule does not produce identical metrics after applying
+	// labels.
+	if vector.ContainsSameLabelset() {
+		err = fmt.Errorf("vector contains metrics with the same labelset after applying rule labels")
+		rule.SetHealth(HealthBad)
+		rule.SetLastError(err)
+		return nil, err
+	}
+
+	rule.mtx.Lock()
+	hooks := append([]EvalHook{LimitHook(limit)}, rule.hooks...)
+	rule.mtx.Unlock()
+
+	vector, err = runEvalHooks(ctx, rule, ts, hooks, vector)
+	if err != nil {
+		rule.SetHealth(HealthBad)
+		rule.SetLastError(err)
+		return nil, err
+	}
+
+	rule.mtx.Lock()
+	defer rule.mtx.Unlock()
+
+	now := rule.clock()
+	current := make(map[uint64]struct{}, len(vector))
+	for _, s := range vector {
+		current[s.Metric.Hash()] = struct{}{}
+	}
+
+	result := append(promql.Vector{}, vector...)
+
+	for fp, prev := range rule.seen {
+		if _, ok := current[fp]; ok {
+			continue
+		}
+		if prev.droppedAt.IsZero() {
+			prev.droppedAt = now
+		}
+		if rule.keepRecordingFor > 0 && now.Sub(prev.droppedAt) < rule.keepRecordingFor {
+			kept := prev.sample
+			kept.Point = promql.Point{T: timestamp.FromTime(ts), V: prev.sample.V}
+			result = append(result, kept)
+			rule.seen[fp] = prev
+			continue
+		}
+		result = append(result, promql.Sample{
+			Metric: prev.sample.Metric,
+			Point:  promql.Point{T: timestamp.FromTime(ts), V: math.Float64frombits(value.StaleNaN)},
+		})
+		delete(rule.seen, fp)
+	}
+
+	for _, s := range vector {
+		rule.seen[s.Metric.Hash()] = seenSeries{sample: s}
+	}
+
+	return result, nil
+}
+
+// SetEvaluationDuration updates evaluationDuration to the duration it took to evaluate the rule on its last evaluation.
+func (rule *RecordingRule) SetEvaluationDuration(dur time.Duration) {
+	rule.mtx.Lock()
+	defer rule.mtx.Unlock()
+	rule.evaluationDuration = dur
+}
+
+// GetEvaluationDuration returns the time in seconds it took to evaluate the last result.
+func (rule *RecordingRule) GetEvaluationDuration() time.Duration {
+	rule.mtx.Lock()
+	defer rule.mtx.Unlock()
+	return rule.evaluationDuration
+}
+
+// SetEvaluationTimestamp updates evaluationTimestamp to the timestamp of when the rule was last evaluated.
+func (rule *RecordingRule) SetEvaluationTimestamp(ts time.Time) {
+	rule.mtx.Lock()
+	defer rule.mtx.Unlock()
+	rule.evaluationTimestamp = ts
+}
+
+// GetEvaluationTimestamp returns the time the rule was last evaluated.
+func (rule *RecordingRule) GetEvaluationTimestamp() time.Time {
+	rule.mtx.Lock()
+	defer rule.mtx.Unlock()
+	return rule.evaluationTimestamp
+}
+
+// SetHealth sets the health of the rule.
+func (rule *RecordingRule) SetHealth(health RuleHealth) {
+	rule.mtx.Lock()
+	defer rule.mtx.Unlock()
+	rule.health = health
+}
+
+// Health returns the current health of the rule.
+func (rule *RecordingRule) Health() RuleHealth {
+	rule.mtx.Lock()
+	defer rule.mtx.Unlock()
+	return rule.health
+}
+
+// SetLastError sets the current error experienced by the rule.
+func (rule *RecordingRule) SetLastError(err error) {
+	rule.mtx.Lock()
+	defer rule.mtx.Unlock()
+	rule.lastError = err
+}
+
+// LastError returns the last error experienced by the rule.
+func (rule *RecordingRule) LastError() error {
+	rule.mtx.Lock()
+	defer rule.mtx.Unlock()
+	return rule.lastError
+}
+
+func (rule *RecordingRule) String() string {
+	ar := rulefmt.Rule{
+		Record: rule.name,
+		Expr:   rule.vector.String(),
+		Labels: rule.labels.Map(),
+	}
+
+	byt, err := yaml.Marshal(ar)
+	if err != nil {
+		return fmt.Sprintf("error marshaling recording rule: %q", err.Error())
+	}
+
+	return string(byt)
+}
@@ -23,6 +23,7 @@ import (
 
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/model/value"
 	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/prometheus/promql/parser"
 	"github.com/prometheus/prometheus/util/teststorage"
@@ -173,6 +174,54 @@ func TestRecordingRuleLimit(t *testing.T) {
 	}
 }
 
+// TestRecordingRuleEvalHooks extends the TestRecordingRuleLimit pattern to
+// a chain of hooks: a label scrubber, an assertion, and the limit itself
+// re-expressed as a hook.
+func TestRecordingRuleEvalHooks(t *testing.T) {
+	suite, err := promql.NewTest(t, `
+		load 1m
+			metric{label="1",secret="x"} 1
+			metric{label="2",secret="x"} 1
+	`)
+	require.NoError(t, err)
+	defer suite.Close()
+
+	require.NoError(t, suite.Run())
+
+	expr, err := parser.ParseExpr(`metric > 0`)
+	require.NoError(t, err)
+	rule := NewRecordingRule(
+		"foo",
+		expr,
+		labels.FromStrings("test", "test"),
+	)
+	rule.AppendEvalHook(ScrubLabelsHook("secret"))
+	rule.AppendEvalHook(AssertHook(ExactSeriesCount(2)))
+
+	evalTime := time.Unix(0, 0)
+
+	result, err := rule.Eval(suite.Context(), evalTime, EngineQueryFunc(suite.QueryEngine(), suite.Storage()), nil, 0)
+	require.NoError(t, err)
+	require.Len(t, result, 2)
+	for _, s := range result {
+		require.Equal(t, "", s.Metric.Get("secret"))
+	}
+
+	// The limit hook still runs first, ahead of the user-registered chain,
+	// and short-circuits evaluation before the assertion sees the vector.
+	_, err = rule.Eval(suite.Context(), evalTime, EngineQueryFunc(suite.QueryEngine(), suite.Storage()), nil, 1)
+	require.EqualError(t, err, "exceeded limit of 1 with 2 series")
+	require.Equal(t, HealthBad, rule.Health())
+
+	// Swap the assertion for one that the result cannot satisfy: evaluation
+	// fails and the rule is marked unhealthy.
+	rule = NewRecordingRule("foo", expr, labels.FromStrings("test", "test"))
+	rule.AppendEvalHook(AssertHook(ExactSeriesCount(5)))
+	_, err = rule.Eval(suite.Context(), evalTime, EngineQueryFunc(suite.QueryEngine(), suite.Storage()), nil, 0)
+	require.EqualError(t, err, "expected exactly 5 series, got 2")
+	require.Equal(t, HealthBad, rule.Health())
+}
+
 func TestNewRecordingRule(t *testing.T) {
 	name := "name"
 	labels := labels.FromStrings("test", "test")
@@ -202,3 +251,136 @@ func TestNewRecordingRule(t *testing.T) {
 	recordingRule.SetLastError(testError)
 	require.Equal(t, testError, recordingRule.LastError())
 }
+
+// TestRecordingRuleStaleness covers a series dropping out of, and back into,
+// the query result across evaluations.
+func TestRecordingRuleStaleness(t *testing.T) {
+	expr, err := parser.ParseExpr(`metric`)
+	require.NoError(t, err)
+
+	rule := NewRecordingRule("foo", expr, labels.FromStrings("test", "test"))
+
+	sample := func(label string, v float64) promql.Sample {
+		return promql.Sample{
+			Metric: labels.FromStrings("label", label),
+			Point:  promql.Point{V: v},
+		}
+	}
+	findByLabel := func(vec promql.Vector, label string) *promql.Sample {
+		for i := range vec {
+			if vec[i].Metric.Get("label") == label {
+				return &vec[i]
+			}
+		}
+		return nil
+	}
+
+	now := time.Unix(0, 0)
+
+	// Initial evaluation: two series present.
+	queryFunc := func(ctx context.Context, q string, t time.Time) (promql.Vector, error) {
+		return promql.Vector{sample("1", 1), sample("2", 2)}, nil
+	}
+	result, err := rule.Eval(context.Background(), now, queryFunc, nil, 0)
+	require.NoError(t, err)
+	require.Len(t, result, 2)
+
+	// Series "2" drops out: a staleness marker is emitted for it alongside
+	// the remaining series.
+	now = now.Add(time.Minute)
+	queryFunc = func(ctx context.Context, q string, t time.Time) (promql.Vector, error) {
+		return promql.Vector{sample("1", 1)}, nil
+	}
+	result, err = rule.Eval(context.Background(), now, queryFunc, nil, 0)
+	require.NoError(t, err)
+	require.Len(t, result, 2)
+	stale := findByLabel(result, "2")
+	require.NotNil(t, stale)
+	require.True(t, value.IsStaleNaN(stale.V))
+
+	// Series "2" stays gone: it was already dropped from tracking, so no
+	// further staleness marker is emitted for it.
+	now = now.Add(time.Minute)
+	result, err = rule.Eval(context.Background(), now, queryFunc, nil, 0)
+	require.NoError(t, err)
+	require.Len(t, result, 1)
+
+	// Series "2" comes back (drop-in).
+	now = now.Add(time.Minute)
+	queryFunc = func(ctx context.Context, q string, t time.Time) (promql.Vector, error) {
+		return promql.Vector{sample("1", 1), sample("2", 5)}, nil
+	}
+	result, err = rule.Eval(context.Background(), now, queryFunc, nil, 0)
+	require.NoError(t, err)
+	require.Len(t, result, 2)
+	require.NotNil(t, findByLabel(result, "2"))
+}
+
+// TestRecordingRuleKeepRecordingFor covers the keep_recording_for window: a
+// dropped series' last value keeps being re-emitted until the window
+// elapses, after which a staleness marker is emitted exactly once.
+func TestRecordingRuleKeepRecordingFor(t *testing.T) {
+	expr, err := parser.ParseExpr(`metric`)
+	require.NoError(t, err)
+
+	rule := NewRecordingRule("foo", expr, labels.FromStrings("test", "test"))
+	rule.SetKeepRecordingFor(2 * time.Minute)
+
+	now := time.Unix(0, 0)
+	rule.clock = func() time.Time { return now }
+
+	queryFunc := func(ctx context.Context, q string, t time.Time) (promql.Vector, error) {
+		return promql.Vector{{
+			Metric: labels.FromStrings("label", "1"),
+			Point:  promql.Point{V: 42},
+		}}, nil
+	}
+	result, err := rule.Eval(context.Background(), now, queryFunc, nil, 0)
+	require.NoError(t, err)
+	require.Len(t, result, 1)
+
+	emptyQueryFunc := func(ctx context.Context, q string, t time.Time) (promql.Vector, error) {
+		return nil, nil
+	}
+
+	// Within the keep_recording_for window, the last value keeps being
+	// re-emitted rather than a staleness marker.
+	now = now.Add(time.Minute)
+	result, err = rule.Eval(context.Background(), now, emptyQueryFunc, nil, 0)
+	require.NoError(t, err)
+	require.Len(t, result, 1)
+	require.Equal(t, 42.0, result[0].V)
+
+	// Once the window has elapsed, a staleness marker is emitted and the
+	// series is dropped from tracking.
+	now = now.Add(2 * time.Minute)
+	result, err = rule.Eval(context.Background(), now, emptyQueryFunc, nil, 0)
+	require.NoError(t, err)
+	require.Len(t, result, 1)
+	require.True(t, value.IsStaleNaN(result[0].V))
+
+	now = now.Add(time.Minute)
+	result, err = rule.Eval(context.Background(), now, emptyQueryFunc, nil, 0)
+	require.NoError(t, err)
+	require.Len(t, result, 0)
+}
+
+// TestRecordingRuleStalenessRestartAfterCrash ensures a freshly constructed
+// rule, which has no memory of a previous process' series, does not emit
+// spurious staleness markers on its first evaluation.
+func TestRecordingRuleStalenessRestartAfterCrash(t *testing.T) {
+	expr, err := parser.ParseExpr(`metric`)
+	require.NoError(t, err)
+
+	rule := NewRecordingRule("foo", expr, labels.FromStrings("test", "test"))
+
+	queryFunc := func(ctx context.Context, q string, t time.Time) (promql.Vector, error) {
+		return promql.Vector{{
+			Metric: labels.FromStrings("label", "1"),
+			Point:  promql.Point{V: 1},
+		}}, nil
+	}
+	result, err := rule.Eval(context.Background(), time.Unix(0, 0), queryFunc, nil, 0)
+	require.NoError(t, err)
+	require.Len(t, result, 1)
+}